@@ -7,151 +7,106 @@ import (
 	"net/http"
 	"reflect"
 	"sync"
-	"time"
 
 	"github.com/pkg/errors"
+	"github.com/sourcegraph/sourcegraph/cmd/query-runner/internal/subscriptions"
+	"github.com/sourcegraph/sourcegraph/cmd/query-runner/internal/subscriptions/postgres"
 	"github.com/sourcegraph/sourcegraph/cmd/query-runner/queryrunnerapi"
 	"github.com/sourcegraph/sourcegraph/pkg/api"
+	"github.com/sourcegraph/sourcegraph/pkg/dbconn"
 	log15 "gopkg.in/inconshreveable/log15.v2"
 )
 
-var allSavedQueries = &allSavedQueriesCached{}
+var (
+	subsOnce sync.Once
+	subs     *subscriptions.Service
+)
 
-// allSavedQueriesCached allows us to get a list of all the saved queries
-// configured for every user/org on the entire server, without the overhead of
-// constantly querying, unmarshaling, and transferring over the network all of
-// the saved query setting values. Instead, we ask for the list once on startup
-// and frontend instances notify us of created/updated/deleted saved queries in
-// user/org configurations.
-type allSavedQueriesCached struct {
-	mu              sync.Mutex
-	allSavedQueries map[string]api.SavedQuerySpecAndConfig
+// getSubscriptions returns the process-wide subscriptions service, which
+// persists saved-search subscriptions in Postgres. It replaces the old
+// allSavedQueriesCached in-memory map, so query-runner survives restarts
+// without needing a full resync from the frontend.
+func getSubscriptions() *subscriptions.Service {
+	subsOnce.Do(func() {
+		subs = subscriptions.NewService(postgres.New(dbconn.Global))
+	})
+	return subs
 }
 
 func savedQueryIDSpecKey(s api.SavedQueryIDSpec) string {
-	return s.Subject.String() + s.Key
-}
-
-// get returns a copy of sq.allSavedQueries to avoid retaining the lock and
-// blocking other oparations that call savedQueryWas[Created|Updated|Deleted]
-// which also need the lock.
-func (sq *allSavedQueriesCached) get() map[string]api.SavedQuerySpecAndConfig {
-	sq.mu.Lock()
-	defer sq.mu.Unlock()
-
-	cpy := make(map[string]api.SavedQuerySpecAndConfig, len(sq.allSavedQueries))
-	for k, v := range sq.allSavedQueries {
-		cpy[k] = v
-	}
-	return cpy
-}
-
-// fetchInitialListFromFrontend blocks until the initial list can be initialized.
-func (sq *allSavedQueriesCached) fetchInitialListFromFrontend() {
-	sq.mu.Lock()
-	defer sq.mu.Unlock()
-
-	attempts := 0
-	for {
-		allSavedQueries, err := api.InternalClient.SavedQueriesListAll(context.Background())
-		if err != nil {
-			if attempts > 3 {
-				// Only print the error if we've retried a few times, otherwise
-				// we would be needlessly verbose when the frontend just hasn't
-				// started yet but will soon.
-				log15.Error("executor: error fetching saved queries list (trying again in 5s)", "error", err)
-			}
-			time.Sleep(5 * time.Second)
-			attempts++
-			continue
-		}
-		sq.allSavedQueries = make(map[string]api.SavedQuerySpecAndConfig, len(allSavedQueries))
-		for spec, config := range allSavedQueries {
-			sq.allSavedQueries[savedQueryIDSpecKey(spec)] = api.SavedQuerySpecAndConfig{
-				Spec:   spec,
-				Config: config.Config,
-			}
-		}
-		log15.Debug("existing saved queries detected", "total_saved_queries", len(sq.allSavedQueries))
-		return
-	}
+	return subscriptions.IDForSpec(s)
 }
 
 func serveSavedQueryWasCreatedOrUpdated(w http.ResponseWriter, r *http.Request) {
-	allSavedQueries.mu.Lock()
-	defer allSavedQueries.mu.Unlock()
-
 	var args *queryrunnerapi.SavedQueryWasCreatedOrUpdatedArgs
 	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
 		writeError(w, errors.Wrap(err, "decoding JSON arguments"))
 		return
 	}
 
+	svc := getSubscriptions()
 	for _, query := range args.SubjectAndConfig.Config.SavedQueries {
 		spec := api.SavedQueryIDSpec{Subject: args.SubjectAndConfig.Subject, Key: query.Key}
-		key := savedQueryIDSpecKey(spec)
-		newValue := api.SavedQuerySpecAndConfig{
-			Spec:   spec,
-			Config: query,
+		newValue := api.SavedQuerySpecAndConfig{Spec: spec, Config: query}
+
+		oldSub, err := svc.CreatedOrUpdated(r.Context(), newValue)
+		if err != nil {
+			writeError(w, errors.Wrap(err, "saving subscription"))
+			return
 		}
 
-		oldValue := allSavedQueries.allSavedQueries[key]
 		if !args.DisableSubscriptionNotifications {
-			// Notify users of saved query creation and updates.
-			go func() {
-				if err := notifySavedQueryWasCreatedOrUpdated(oldValue, newValue); err != nil {
-					log15.Error("Failed to handle created/updated saved search.", "query", query, "error", err)
-				}
-			}()
+			// oldSub is the pre-mutation snapshot CreatedOrUpdated returned,
+			// and newSub carries forward the same sinks it just persisted
+			// (CreatedOrUpdated never changes Sinks): both are captured
+			// before/at the mutation, not re-read from the row afterwards.
+			newSub := subscriptions.Subscription{ID: savedQueryIDSpecKey(spec), SavedQuerySpecAndConfig: newValue, Sinks: oldSub.Sinks}
+			go notifySavedQueryWasCreatedOrUpdated(oldSub, newSub)
 		}
-
-		allSavedQueries.allSavedQueries[key] = newValue
 	}
-	log15.Info("saved query created or updated", "total_saved_queries", len(allSavedQueries.allSavedQueries))
+	log15.Info("saved query created or updated", "key", args.SubjectAndConfig.Subject)
 	w.WriteHeader(http.StatusOK)
 }
 
 func serveSavedQueryWasDeleted(w http.ResponseWriter, r *http.Request) {
-	allSavedQueries.mu.Lock()
-	defer allSavedQueries.mu.Unlock()
-
 	var args *queryrunnerapi.SavedQueryWasDeletedArgs
 	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
 		writeError(w, errors.Wrap(err, "decoding JSON arguments"))
 		return
 	}
 
-	key := savedQueryIDSpecKey(args.Spec)
-	query, ok := allSavedQueries.allSavedQueries[key]
+	svc := getSubscriptions()
+	deletedSub, ok, err := svc.Deleted(r.Context(), args.Spec)
+	if err != nil {
+		writeError(w, errors.Wrap(err, "removing subscription"))
+		return
+	}
 	if !ok {
 		return // query to delete already doesn't exist; do nothing
 	}
-	delete(allSavedQueries.allSavedQueries, key)
 
 	if !args.DisableSubscriptionNotifications {
-		// Notify users of saved query deletions.
-		go func() {
-			if err := notifySavedQueryWasCreatedOrUpdated(query, api.SavedQuerySpecAndConfig{}); err != nil {
-				log15.Error("Failed to handle created/updated saved search.", "query", query, "error", err)
-			}
-		}()
+		// deletedSub is the snapshot Deleted returned from just before it
+		// removed the row, so its Sinks (and therefore recipients) are
+		// still available even though the row itself is already gone.
+		go notifySavedQueryWasCreatedOrUpdated(deletedSub, subscriptions.Subscription{})
 	}
 
-	// Delete from database, but only if another saved query is not the same.
-	anotherExists := false
-	for _, other := range allSavedQueries.allSavedQueries {
-		if other.Config.Query == query.Config.Query {
-			anotherExists = true
-			break
-		}
+	// Delete from database, but only if another subscription isn't for the
+	// same query text. ByTopic replaces the old O(n) scan over the full
+	// in-memory map with a single indexed lookup.
+	others, err := svc.ByTopic(r.Context(), deletedSub.Config.Query)
+	if err != nil {
+		log15.Error("Failed to list subscriptions by topic", "error", err)
+		return
 	}
-	if !anotherExists {
-		if err := api.InternalClient.SavedQueriesDeleteInfo(r.Context(), query.Config.Query); err != nil {
+	if len(others) == 0 {
+		if err := api.InternalClient.SavedQueriesDeleteInfo(r.Context(), deletedSub.Config.Query); err != nil {
 			log15.Error("Failed to delete saved query from DB: SavedQueriesDeleteInfo", "error", err)
 			return
 		}
 	}
-	log15.Info("saved query deleted", "total_saved_queries", len(allSavedQueries.allSavedQueries))
+	log15.Info("saved query deleted", "spec", args.Spec)
 }
 
 // diffSavedQueryConfigs takes the old and new saved queries configurations.
@@ -211,80 +166,85 @@ func sendNotificationsForCreatedOrUpdatedOrDeleted(oldList, newList map[api.Save
 	for oldVal, newVal := range deleted {
 		oldVal := oldVal
 		newVal := newVal
-		go func() {
-			if err := notifySavedQueryWasCreatedOrUpdated(oldVal, newVal); err != nil {
-				log15.Error("Failed to handle deleted saved search.", "query", oldVal.Config.Query, "error", err)
-
-			}
-		}()
+		go notifySavedQueryWasCreatedOrUpdated(specAndConfigToSubscription(oldVal), specAndConfigToSubscription(newVal))
 	}
 	for oldVal, newVal := range created {
 		oldVal := oldVal
 		newVal := newVal
-		go func() {
-			if err := notifySavedQueryWasCreatedOrUpdated(oldVal, newVal); err != nil {
-				log15.Error("Failed to handle deleted saved search.", "query", oldVal.Config.Query, "error", err)
-
-			}
-		}()
+		go notifySavedQueryWasCreatedOrUpdated(specAndConfigToSubscription(oldVal), specAndConfigToSubscription(newVal))
 	}
 	for oldVal, newVal := range updated {
 		oldVal := oldVal
 		newVal := newVal
-		go func() {
-			if err := notifySavedQueryWasCreatedOrUpdated(oldVal, newVal); err != nil {
-				log15.Error("Failed to handle deleted saved search.", "query", oldVal.Config.Query, "error", err)
+		go notifySavedQueryWasCreatedOrUpdated(specAndConfigToSubscription(oldVal), specAndConfigToSubscription(newVal))
+	}
+}
 
-			}
-		}()
+// specAndConfigToSubscription wraps specAndConfig as a zero-Sinks
+// Subscription, for the legacy diff-based notification path above, which
+// (unlike serveSavedQueryWasCreatedOrUpdated/serveSavedQueryWasDeleted)
+// never had access to a subscriber's configured sinks to begin with.
+func specAndConfigToSubscription(specAndConfig api.SavedQuerySpecAndConfig) subscriptions.Subscription {
+	if (specAndConfig == api.SavedQuerySpecAndConfig{}) {
+		return subscriptions.Subscription{}
 	}
+	return subscriptions.Subscription{ID: savedQueryIDSpecKey(specAndConfig.Spec), SavedQuerySpecAndConfig: specAndConfig}
 }
 
-func notifySavedQueryWasCreatedOrUpdated(oldValue, newValue api.SavedQuerySpecAndConfig) error {
+// notifySavedQueryWasCreatedOrUpdated notifies the difference between
+// oldSub's and newSub's recipients that a saved query was subscribed to
+// or unsubscribed from. Both must be snapshots taken before (or, for
+// newSub, built from the same sinks as) the mutation that produced them —
+// see CreatedOrUpdated and Deleted's doc comments — since recipients are
+// derived from each Subscription's own Sinks field rather than looked up
+// fresh.
+func notifySavedQueryWasCreatedOrUpdated(oldSub, newSub subscriptions.Subscription) {
 	ctx := context.Background()
 
-	oldRecipients, err := getNotificationRecipients(ctx, oldValue.Spec, oldValue.Config)
-	if err != nil {
-		return err
-	}
-	newRecipients, err := getNotificationRecipients(ctx, newValue.Spec, newValue.Config)
-	if err != nil {
-		return err
-	}
+	oldRecipients := getNotificationRecipients(oldSub)
+	newRecipients := getNotificationRecipients(newSub)
 
 	removedRecipients, addedRecipients := diffNotificationRecipients(oldRecipients, newRecipients)
 	log15.Debug("Notifying for created/updated saved search", "removed", removedRecipients, "added", addedRecipients)
 	for _, removedRecipient := range removedRecipients {
-		if removedRecipient.email {
-			if err := emailNotifySubscribeUnsubscribe(ctx, removedRecipient, oldValue, notifyUnsubscribedTemplate); err != nil {
-				log15.Error("Failed to send unsubscribed email notification.", "recipient", removedRecipient, "error", err)
-			}
-		}
-		if removedRecipient.slack {
-			if err := slackNotifyUnsubscribed(ctx, removedRecipient, oldValue); err != nil {
-				log15.Error("Failed to send unsubscribed Slack notification.", "recipient", removedRecipient, "error", err)
-			}
-		}
+		notifyRecipient(ctx, removedRecipient, notificationEvent{Kind: notificationUnsubscribed, Query: oldSub.SavedQuerySpecAndConfig})
 	}
 	for _, addedRecipient := range addedRecipients {
-		if addedRecipient.email {
-			if err := emailNotifySubscribeUnsubscribe(ctx, addedRecipient, newValue, notifySubscribedTemplate); err != nil {
-				log15.Error("Failed to send subscribed email notification.", "recipient", addedRecipient, "error", err)
-			}
-		}
-		if addedRecipient.slack {
-			if err := slackNotifySubscribed(ctx, addedRecipient, newValue); err != nil {
-				log15.Error("Failed to send subscribed Slack notification.", "recipient", addedRecipient, "error", err)
-			}
-		}
+		notifyRecipient(ctx, addedRecipient, notificationEvent{Kind: notificationSubscribed, Query: newSub.SavedQuerySpecAndConfig})
+	}
+}
+
+// notifySavedQueryMatched notifies spec/config's recipients that running
+// the saved search produced results. The saved-search executor (the
+// scheduler that actually runs queries on a timer) calls this once per
+// run that finds matches; it is the counterpart to
+// notifySavedQueryWasCreatedOrUpdated for "this search found something",
+// rather than "this search's subscription changed".
+func notifySavedQueryMatched(ctx context.Context, spec api.SavedQueryIDSpec, config api.ConfigSavedQuery, matches interface{}, resultCount int) error {
+	sub, err := getSubscriptions().Get(ctx, savedQueryIDSpecKey(spec))
+	if err == subscriptions.ErrNotFound {
+		// The subscription was deleted since the executor last listed it;
+		// nothing to notify.
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "looking up subscription")
+	}
+	recipients := getNotificationRecipients(sub)
+
+	query := api.SavedQuerySpecAndConfig{Spec: spec, Config: config}
+	for _, recipient := range recipients {
+		notifyRecipient(ctx, recipient, notificationEvent{
+			Kind:        notificationMatch,
+			Query:       query,
+			Matches:     matches,
+			ResultCount: resultCount,
+		})
 	}
 	return nil
 }
 
 func serveTestNotification(w http.ResponseWriter, r *http.Request) {
-	allSavedQueries.mu.Lock()
-	defer allSavedQueries.mu.Unlock()
-
 	var args *queryrunnerapi.TestNotificationArgs
 	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
 		writeError(w, errors.Wrap(err, "decoding JSON arguments"))
@@ -292,26 +252,22 @@ func serveTestNotification(w http.ResponseWriter, r *http.Request) {
 	}
 
 	key := savedQueryIDSpecKey(args.Spec)
-	query, ok := allSavedQueries.allSavedQueries[key]
-	if !ok {
+	query, err := getSubscriptions().Get(r.Context(), key)
+	if err == subscriptions.ErrNotFound {
 		writeError(w, fmt.Errorf("no saved search found with key %q", key))
 		return
 	}
-
-	recipients, err := getNotificationRecipients(r.Context(), query.Spec, query.Config)
 	if err != nil {
-		writeError(w, fmt.Errorf("error computing recipients: %s", err))
+		writeError(w, errors.Wrap(err, "looking up subscription"))
 		return
 	}
 
+	recipients := getNotificationRecipients(query)
+
+	specAndConfig := api.SavedQuerySpecAndConfig{Spec: query.Spec, Config: query.Config}
 	for _, recipient := range recipients {
-		if err := emailNotifySubscribeUnsubscribe(r.Context(), recipient, query, notifySubscribedTemplate); err != nil {
-			writeError(w, fmt.Errorf("error sending email notifications to %s: %s", recipient.spec, err))
-			return
-		}
-		if err := slackNotify(context.Background(), recipient,
-			fmt.Sprintf(`It worked! This is a test notification for the Sourcegraph saved search <%s|"%s">.`, searchURL(query.Config.Query, utmSourceSlack), query.Config.Description)); err != nil {
-			writeError(w, fmt.Errorf("error sending email notifications to %s: %s", recipient.spec, err))
+		if err := notifyRecipientSync(r.Context(), recipient, notificationEvent{Kind: notificationTest, Query: specAndConfig}); err != nil {
+			writeError(w, fmt.Errorf("error sending test notification to %s: %s", recipient.spec, err))
 			return
 		}
 	}
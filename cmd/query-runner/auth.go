@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/sourcegraph/cmd/query-runner/queryrunnerapi"
+	"golang.org/x/time/rate"
+)
+
+// internalToken is the shared secret every internal caller (see
+// queryrunnerapi.Client) must present as a bearer token. It's read once
+// from QUERY_RUNNER_INTERNAL_TOKEN; when unset, requireInternalToken is a
+// no-op, so an old frontend that doesn't send a token yet (e.g. mid
+// rolling upgrade) keeps working instead of getting locked out.
+var internalToken = os.Getenv("QUERY_RUNNER_INTERNAL_TOKEN")
+
+// authError is the structured JSON body written for 401/429 responses.
+type authError struct {
+	Error string `json:"error"`
+}
+
+func writeAuthError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(authError{Error: message})
+}
+
+// requireInternalToken wraps next so it only runs for requests bearing
+// "Authorization: Bearer <QUERY_RUNNER_INTERNAL_TOKEN>". If
+// QUERY_RUNNER_INTERNAL_TOKEN is unset on this deployment, every request
+// is let through unchanged.
+func requireInternalToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if internalToken == "" {
+			next(w, r)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		got := strings.TrimPrefix(auth, "Bearer ")
+		if got == auth || got != internalToken {
+			writeAuthError(w, http.StatusUnauthorized, "missing or invalid Authorization bearer token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// testNotificationRateLimit bounds how often a single subject (user/org)
+// can trigger /test-notification, so a runaway frontend or a malicious
+// internal client can't flood a user's email/Slack/SMS by hammering the
+// endpoint.
+const testNotificationRateLimit = 10 // per minute, per subject
+
+// testNotificationLimiterTTL bounds how long an idle subject's limiter is
+// kept around. Without this, a caller varying the subject on every
+// request (e.g. a malicious internal client) would grow
+// testNotificationLimiters without bound, since a limiter is otherwise
+// never removed once created.
+const testNotificationLimiterTTL = 10 * time.Minute
+
+// testNotificationLimiter pairs a subject's token bucket with the last
+// time it was used, so evictTestNotificationLimiters can sweep out
+// entries nobody has touched in a while.
+type testNotificationLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+var (
+	testNotificationLimitersMu sync.Mutex
+	testNotificationLimiters   = map[string]*testNotificationLimiter{}
+)
+
+func init() {
+	go evictTestNotificationLimiters()
+}
+
+func testNotificationLimiterFor(subject string) *rate.Limiter {
+	testNotificationLimitersMu.Lock()
+	defer testNotificationLimitersMu.Unlock()
+
+	entry, ok := testNotificationLimiters[subject]
+	if !ok {
+		entry = &testNotificationLimiter{limiter: rate.NewLimiter(rate.Limit(testNotificationRateLimit)/60, testNotificationRateLimit)}
+		testNotificationLimiters[subject] = entry
+	}
+	entry.lastUsed = time.Now()
+	return entry.limiter
+}
+
+// evictTestNotificationLimiters periodically removes limiters that
+// haven't been used in testNotificationLimiterTTL, so the map doesn't
+// grow unbounded. It runs for the lifetime of the process.
+func evictTestNotificationLimiters() {
+	ticker := time.NewTicker(testNotificationLimiterTTL)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-testNotificationLimiterTTL)
+		testNotificationLimitersMu.Lock()
+		for subject, entry := range testNotificationLimiters {
+			if entry.lastUsed.Before(cutoff) {
+				delete(testNotificationLimiters, subject)
+			}
+		}
+		testNotificationLimitersMu.Unlock()
+	}
+}
+
+// rateLimitTestNotifications wraps a /test-notification handler with a
+// per-subject token bucket. It peeks at the request body to find the
+// subject, then restores it so the wrapped handler can still decode it.
+func rateLimitTestNotifications(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, errors.Wrap(err, "reading request body"))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var args queryrunnerapi.TestNotificationArgs
+		if err := json.Unmarshal(body, &args); err != nil {
+			writeError(w, errors.Wrap(err, "decoding JSON arguments"))
+			return
+		}
+
+		if !testNotificationLimiterFor(args.Spec.Subject.String()).Allow() {
+			writeAuthError(w, http.StatusTooManyRequests, "rate limit exceeded: at most 10 test notifications per minute per subject")
+			return
+		}
+		next(w, r)
+	}
+}
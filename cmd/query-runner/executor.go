@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sourcegraph/sourcegraph/cmd/query-runner/internal/subscriptions"
+	"github.com/sourcegraph/sourcegraph/pkg/api"
+	log15 "gopkg.in/inconshreveable/log15.v2"
+)
+
+// executorPageSize bounds how many subscriptions checkAllSavedQueries
+// retrieves per RetrieveAll call, so a large subscriber base is walked in
+// batches instead of loaded into memory all at once.
+const executorPageSize = 100
+
+// defaultExecutionInterval is how often runSavedQueryExecutor re-checks
+// every saved search for new results, absent QUERY_RUNNER_EXECUTION_INTERVAL.
+const defaultExecutionInterval = 5 * time.Minute
+
+// runSavedQueryExecutor periodically re-runs every subscribed saved search,
+// whether or not it has any sinks configured, and calls
+// notifySavedQueryMatched for any that found results. It is query-runner's counterpart to the frontend's
+// saved-query CRUD notifications (notifySavedQueryWasCreatedOrUpdated):
+// those fire on subscription changes, this fires on the search itself
+// producing something new to look at.
+func runSavedQueryExecutor(ctx context.Context) {
+	interval := defaultExecutionInterval
+	if s := os.Getenv("QUERY_RUNNER_EXECUTION_INTERVAL"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			interval = d
+		} else if n, err := strconv.Atoi(s); err == nil {
+			interval = time.Duration(n) * time.Second
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := checkAllSavedQueries(ctx); err != nil {
+			log15.Error("executor: failed to check saved queries", "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkAllSavedQueries walks every subscription, page by page, and checks
+// each one for new results.
+func checkAllSavedQueries(ctx context.Context) error {
+	offset := 0
+	for {
+		page, err := getSubscriptions().List(ctx, subscriptions.Filter{}, subscriptions.PageInfo{Offset: offset, Limit: executorPageSize})
+		if err != nil {
+			return err
+		}
+		for _, sub := range page.Subscriptions {
+			if err := checkSavedQuery(ctx, sub); err != nil {
+				log15.Error("executor: failed to check saved query", "query", sub.Config.Query, "error", err)
+			}
+		}
+		offset += len(page.Subscriptions)
+		if offset >= page.Total || len(page.Subscriptions) == 0 {
+			return nil
+		}
+	}
+}
+
+// checkSavedQuery re-runs a single saved search and, if it matched
+// anything, notifies its subscribers via notifySavedQueryMatched.
+func checkSavedQuery(ctx context.Context, sub subscriptions.Subscription) error {
+	results, resultCount, err := api.InternalClient.SearchSavedQuery(ctx, sub.Config.Query)
+	if err != nil {
+		return err
+	}
+	if resultCount == 0 {
+		return nil
+	}
+	return notifySavedQueryMatched(ctx, sub.Spec, sub.Config, results, resultCount)
+}
@@ -0,0 +1,96 @@
+// Package smpp opens a persistent SMPP v3.4 transceiver session to a
+// configured SMSC and sends SMS messages over it, following the
+// smpp-notifier pattern: a small client wrapping the wire protocol so the rest of
+// query-runner only has to deal with a Config and a Send call.
+package smpp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
+)
+
+// Config describes how to connect and bind to an SMSC as an SMPP v3.4
+// transceiver.
+type Config struct {
+	Host       string
+	Port       int
+	SystemID   string
+	Password   string
+	SystemType string
+
+	// SourceAddr is the sender address (e.g. a short code or alphanumeric
+	// sender ID) submitted as the source_addr of every message sent
+	// through this Client.
+	SourceAddr string
+
+	// SourceAddrTON/SourceAddrNPI set the Type-of-Number and
+	// Numbering-Plan-Indicator describing SourceAddr's format, per SMPP
+	// v3.4 §5.2.5/§5.2.6.
+	SourceAddrTON uint8
+	SourceAddrNPI uint8
+}
+
+// Client is a persistent SMPP v3.4 transceiver session to a single SMSC.
+type Client struct {
+	tx  *smpp.Transceiver
+	cfg Config
+}
+
+// Dial opens and binds a transceiver session to the SMSC described by
+// cfg. The underlying library keeps the session alive and re-binds it in
+// the background if the connection drops; Dial itself only waits for the
+// first bind attempt, so callers get an early error if the SMSC is
+// unreachable or the credentials are wrong.
+func Dial(cfg Config) (*Client, error) {
+	tx := &smpp.Transceiver{
+		Addr:         fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		User:         cfg.SystemID,
+		Passwd:       cfg.Password,
+		SystemType:   cfg.SystemType,
+		BindInterval: 5 * time.Second,
+	}
+	conn := tx.Bind()
+	if status := <-conn; status.Status() != smpp.Connected {
+		tx.Close()
+		return nil, fmt.Errorf("smpp: bind to %s failed: %v", tx.Addr, status.Error())
+	}
+	return &Client{tx: tx, cfg: cfg}, nil
+}
+
+// gsm7SingleSegmentMax is the number of GSM-7 septets that fit in a
+// single SubmitSM PDU. Bodies longer than this must go through
+// SubmitLongMsg, which splits them into multiple PDUs with UDH
+// concatenation headers, instead of Submit, which does not segment long
+// messages at all and would otherwise have them truncated or rejected by
+// the SMSC.
+const gsm7SingleSegmentMax = 160
+
+// Send submits body to dest (an MSISDN). Messages longer than a single
+// 160 character GSM-7 SMS are split into multipart messages via
+// SubmitLongMsg.
+func (c *Client) Send(dest, body string) error {
+	sm := &smpp.ShortMessage{
+		Src:           c.cfg.SourceAddr,
+		Dst:           dest,
+		Text:          pdutext.GSM7(body),
+		Register:      smpp.NoDeliveryReceipt,
+		SourceAddrTON: c.cfg.SourceAddrTON,
+		SourceAddrNPI: c.cfg.SourceAddrNPI,
+	}
+	// The unpacked GSM-7 encoding is one byte per septet (two for
+	// escaped extension characters), so its length is the septet count.
+	if len(sm.Text.Encode()) <= gsm7SingleSegmentMax {
+		_, err := c.tx.Submit(sm)
+		return err
+	}
+	_, err := c.tx.SubmitLongMsg(sm)
+	return err
+}
+
+// Close unbinds and closes the session.
+func (c *Client) Close() error {
+	return c.tx.Close()
+}
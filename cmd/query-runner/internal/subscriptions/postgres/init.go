@@ -0,0 +1,43 @@
+package postgres
+
+import migrate "github.com/rubenv/sql-migrate"
+
+// Migration returns the versioned migrations needed to create and evolve
+// the query_runner_subscriptions table. It follows the in-binary
+// migration pattern used by mainflux's notifiers subsystem rather than
+// bindata generated from a migrations/ directory, since this table lives
+// entirely within query-runner's own schema.
+func Migration() *migrate.MemoryMigrationSource {
+	return &migrate.MemoryMigrationSource{
+		Migrations: []*migrate.Migration{
+			{
+				Id: "subscriptions_1",
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS query_runner_subscriptions (
+						id         VARCHAR(512) PRIMARY KEY,
+						owner      VARCHAR(512) NOT NULL,
+						topic      TEXT NOT NULL,
+						spec       JSONB NOT NULL,
+						config     JSONB NOT NULL,
+						created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+						updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+					)`,
+					`CREATE INDEX query_runner_subscriptions_owner_idx ON query_runner_subscriptions (owner)`,
+					`CREATE INDEX query_runner_subscriptions_topic_idx ON query_runner_subscriptions (topic)`,
+				},
+				Down: []string{
+					`DROP TABLE query_runner_subscriptions`,
+				},
+			},
+			{
+				Id: "subscriptions_2",
+				Up: []string{
+					`ALTER TABLE query_runner_subscriptions ADD COLUMN sinks JSONB NOT NULL DEFAULT '{}'::jsonb`,
+				},
+				Down: []string{
+					`ALTER TABLE query_runner_subscriptions DROP COLUMN sinks`,
+				},
+			},
+		},
+	}
+}
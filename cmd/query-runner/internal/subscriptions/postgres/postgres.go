@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sourcegraph/sourcegraph/cmd/query-runner/internal/subscriptions"
+)
+
+// repository is a subscriptions.Repository backed by Postgres.
+type repository struct {
+	db *sql.DB
+}
+
+// New returns a subscriptions.Repository backed by db. Callers must have
+// already applied Migration() against db (query-runner does this once on
+// startup, the same way other Sourcegraph services migrate their own
+// schemas).
+func New(db *sql.DB) subscriptions.Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) Save(ctx context.Context, sub subscriptions.Subscription) (string, error) {
+	spec, err := json.Marshal(sub.Spec)
+	if err != nil {
+		return "", err
+	}
+	config, err := json.Marshal(sub.Config)
+	if err != nil {
+		return "", err
+	}
+	sinks, err := json.Marshal(sub.Sinks)
+	if err != nil {
+		return "", err
+	}
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO query_runner_subscriptions (id, owner, topic, spec, config, sinks, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, now())
+		ON CONFLICT (id) DO UPDATE SET
+			owner      = excluded.owner,
+			topic      = excluded.topic,
+			spec       = excluded.spec,
+			config     = excluded.config,
+			sinks      = excluded.sinks,
+			updated_at = now()
+	`, sub.ID, sub.Owner(), sub.Topic(), spec, config, sinks)
+	if err != nil {
+		return "", err
+	}
+	return sub.ID, nil
+}
+
+func (r *repository) Retrieve(ctx context.Context, id string) (subscriptions.Subscription, error) {
+	var spec, config, sinks []byte
+	err := r.db.QueryRowContext(ctx, `SELECT spec, config, sinks FROM query_runner_subscriptions WHERE id = $1`, id).Scan(&spec, &config, &sinks)
+	if err == sql.ErrNoRows {
+		return subscriptions.Subscription{}, subscriptions.ErrNotFound
+	}
+	if err != nil {
+		return subscriptions.Subscription{}, err
+	}
+
+	sub := subscriptions.Subscription{ID: id}
+	if err := json.Unmarshal(spec, &sub.Spec); err != nil {
+		return subscriptions.Subscription{}, err
+	}
+	if err := json.Unmarshal(config, &sub.Config); err != nil {
+		return subscriptions.Subscription{}, err
+	}
+	if err := json.Unmarshal(sinks, &sub.Sinks); err != nil {
+		return subscriptions.Subscription{}, err
+	}
+	return sub, nil
+}
+
+func (r *repository) RetrieveAll(ctx context.Context, filter subscriptions.Filter, page subscriptions.PageInfo) (subscriptions.Page, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if filter.Owner != "" {
+		args = append(args, filter.Owner)
+		where = append(where, fmt.Sprintf("owner = $%d", len(args)))
+	}
+	if filter.Topic != "" {
+		args = append(args, filter.Topic)
+		where = append(where, fmt.Sprintf("topic = $%d", len(args)))
+	}
+	whereSQL := ""
+	if len(where) > 0 {
+		whereSQL = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*) FROM query_runner_subscriptions %s`, whereSQL), args...).Scan(&total); err != nil {
+		return subscriptions.Page{}, err
+	}
+
+	query := fmt.Sprintf(`SELECT id, spec, config, sinks FROM query_runner_subscriptions %s ORDER BY id`, whereSQL)
+	if page.Limit > 0 {
+		args = append(args, page.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+		args = append(args, page.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return subscriptions.Page{}, err
+	}
+	defer rows.Close()
+
+	result := subscriptions.Page{Total: total}
+	for rows.Next() {
+		var (
+			id                  string
+			spec, config, sinks []byte
+		)
+		if err := rows.Scan(&id, &spec, &config, &sinks); err != nil {
+			return subscriptions.Page{}, err
+		}
+		sub := subscriptions.Subscription{ID: id}
+		if err := json.Unmarshal(spec, &sub.Spec); err != nil {
+			return subscriptions.Page{}, err
+		}
+		if err := json.Unmarshal(config, &sub.Config); err != nil {
+			return subscriptions.Page{}, err
+		}
+		if err := json.Unmarshal(sinks, &sub.Sinks); err != nil {
+			return subscriptions.Page{}, err
+		}
+		result.Subscriptions = append(result.Subscriptions, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return subscriptions.Page{}, err
+	}
+	return result, nil
+}
+
+func (r *repository) Remove(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM query_runner_subscriptions WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,116 @@
+package subscriptions
+
+import (
+	"context"
+
+	"github.com/sourcegraph/sourcegraph/pkg/api"
+)
+
+// Service is the entry point query-runner's HTTP handlers use to react to
+// saved queries being created, updated, or deleted. It keeps Repository
+// as the single source of truth, so the handlers become thin wrappers
+// instead of mutating a process-local cache directly.
+type Service struct {
+	repo Repository
+}
+
+// NewService returns a Service backed by repo.
+func NewService(repo Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// CreatedOrUpdated persists newValue as a subscription, and returns the
+// subscription's previous state in full (the zero value if it didn't
+// already exist) — including its Sinks, which getNotificationRecipients
+// needs to compute who to notify from a pre-mutation snapshot, rather
+// than reading the (already-updated) row back afterwards.
+func (s *Service) CreatedOrUpdated(ctx context.Context, newValue api.SavedQuerySpecAndConfig) (old Subscription, err error) {
+	id := IDForSpec(newValue.Spec)
+
+	existing, err := s.repo.Retrieve(ctx, id)
+	if err != nil && err != ErrNotFound {
+		return Subscription{}, err
+	}
+	// Carry the subscriber's existing sinks forward: this call only
+	// updates the saved query's spec/config, and must not reset whatever
+	// email/Slack/webhook/phone sinks they already configured via
+	// Service.SetSinks back to the zero value.
+	var sinks Sinks
+	if err == nil {
+		old = existing
+		sinks = existing.Sinks
+	}
+
+	sub := Subscription{ID: id, SavedQuerySpecAndConfig: newValue, Sinks: sinks}
+	if _, err := s.repo.Save(ctx, sub); err != nil {
+		return Subscription{}, err
+	}
+	return old, nil
+}
+
+// SetSinks updates the notification sinks for the subscription with the
+// given ID, leaving its spec/config untouched. It returns ErrNotFound if
+// no subscription exists with that ID.
+func (s *Service) SetSinks(ctx context.Context, id string, sinks Sinks) error {
+	sub, err := s.repo.Retrieve(ctx, id)
+	if err != nil {
+		return err
+	}
+	sub.Sinks = sinks
+	_, err = s.repo.Save(ctx, sub)
+	return err
+}
+
+// Deleted removes the subscription for spec and returns the full value it
+// had (including Sinks) just before removal, so callers can still notify
+// its recipients afterwards without reading back a row that's already
+// gone. ok is false if no subscription existed for spec, in which case
+// callers should treat the delete as a no-op.
+func (s *Service) Deleted(ctx context.Context, spec api.SavedQueryIDSpec) (old Subscription, ok bool, err error) {
+	id := IDForSpec(spec)
+
+	existing, err := s.repo.Retrieve(ctx, id)
+	if err == ErrNotFound {
+		return Subscription{}, false, nil
+	}
+	if err != nil {
+		return Subscription{}, false, err
+	}
+	if err := s.repo.Remove(ctx, id); err != nil {
+		return Subscription{}, false, err
+	}
+	return existing, true, nil
+}
+
+// ByTopic lists subscriptions whose saved query text equals topic, across
+// every owner. serveSavedQueryWasDeleted uses this to decide whether
+// another subscriber's saved query shares the same query text before
+// asking the frontend to delete its cached query info; this replaces the
+// old O(n) scan over the full in-memory map.
+func (s *Service) ByTopic(ctx context.Context, topic string) ([]Subscription, error) {
+	page, err := s.repo.RetrieveAll(ctx, Filter{Topic: topic}, PageInfo{})
+	if err != nil {
+		return nil, err
+	}
+	return page.Subscriptions, nil
+}
+
+// ByOwner lists the subscriptions owned by the given user/org, paginated.
+func (s *Service) ByOwner(ctx context.Context, owner string, page PageInfo) (Page, error) {
+	return s.repo.RetrieveAll(ctx, Filter{Owner: owner}, page)
+}
+
+// List lists subscriptions matching filter, paginated.
+func (s *Service) List(ctx context.Context, filter Filter, page PageInfo) (Page, error) {
+	return s.repo.RetrieveAll(ctx, filter, page)
+}
+
+// Get returns the subscription with the given ID.
+func (s *Service) Get(ctx context.Context, id string) (Subscription, error) {
+	return s.repo.Retrieve(ctx, id)
+}
+
+// Remove deletes the subscription with the given ID.
+func (s *Service) Remove(ctx context.Context, id string) error {
+	return s.repo.Remove(ctx, id)
+}
@@ -0,0 +1,81 @@
+package subscriptions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sourcegraph/sourcegraph/pkg/api"
+)
+
+// fakeRepository is an in-memory Repository for testing Service without a
+// real Postgres connection.
+type fakeRepository struct {
+	byID map[string]Subscription
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{byID: map[string]Subscription{}}
+}
+
+func (r *fakeRepository) Save(ctx context.Context, sub Subscription) (string, error) {
+	r.byID[sub.ID] = sub
+	return sub.ID, nil
+}
+
+func (r *fakeRepository) Retrieve(ctx context.Context, id string) (Subscription, error) {
+	sub, ok := r.byID[id]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	return sub, nil
+}
+
+func (r *fakeRepository) RetrieveAll(ctx context.Context, filter Filter, page PageInfo) (Page, error) {
+	var subs []Subscription
+	for _, sub := range r.byID {
+		subs = append(subs, sub)
+	}
+	return Page{Subscriptions: subs, Total: len(subs)}, nil
+}
+
+func (r *fakeRepository) Remove(ctx context.Context, id string) error {
+	delete(r.byID, id)
+	return nil
+}
+
+// TestService_CreatedOrUpdated_PreservesSinks guards against a regression
+// where updating a saved query's spec/config silently reset the owner's
+// already-configured notification sinks back to the zero value.
+func TestService_CreatedOrUpdated_PreservesSinks(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepository()
+	svc := NewService(repo)
+
+	spec := api.SavedQueryIDSpec{Key: "q1"}
+	original := api.SavedQuerySpecAndConfig{Spec: spec, Config: api.ConfigSavedQuery{Query: "foo"}}
+
+	if _, err := svc.CreatedOrUpdated(ctx, original); err != nil {
+		t.Fatal(err)
+	}
+
+	id := IDForSpec(spec)
+	wantSinks := Sinks{Email: true, Webhook: &WebhookSink{URL: "https://example.com/hook"}}
+	if err := svc.SetSinks(ctx, id, wantSinks); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unrelated edit to the saved query's config must not wipe the
+	// sinks set above.
+	updated := api.SavedQuerySpecAndConfig{Spec: spec, Config: api.ConfigSavedQuery{Query: "bar"}}
+	if _, err := svc.CreatedOrUpdated(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := svc.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Sinks.Email != wantSinks.Email || got.Sinks.Webhook == nil || got.Sinks.Webhook.URL != wantSinks.Webhook.URL {
+		t.Fatalf("sinks were not preserved across CreatedOrUpdated: got %+v, want %+v", got.Sinks, wantSinks)
+	}
+}
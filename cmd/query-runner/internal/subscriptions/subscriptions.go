@@ -0,0 +1,117 @@
+// Package subscriptions stores saved-search subscriptions (which
+// user/org is subscribed to which saved query) so that query-runner no
+// longer has to keep the entire set in memory and rebuild it from the
+// frontend on every restart.
+//
+// The shape of this package (a narrow Repository interface plus a
+// Postgres implementation) follows the subscriptions repository used by
+// the mainflux notifiers subsystem.
+package subscriptions
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sourcegraph/sourcegraph/pkg/api"
+)
+
+// ErrNotFound is returned by Repository methods when no subscription
+// matches the given ID.
+var ErrNotFound = errors.New("subscription not found")
+
+// Subscription is a single saved-search subscription: the saved query a
+// user or org is subscribed to, and the spec/config needed to compute who
+// should be notified and what to notify them with.
+type Subscription struct {
+	// ID uniquely identifies the subscription. It is derived from the
+	// subject and saved query key, matching the key historically used by
+	// the in-memory allSavedQueriesCached map.
+	ID string
+
+	api.SavedQuerySpecAndConfig
+
+	// Sinks configures which channels this subscription's owner should be
+	// notified through. It is the zero value (nothing configured) until
+	// the owner sets it via Service.SetSinks.
+	Sinks Sinks
+}
+
+// Sinks is the set of notification channels a subscription's owner has
+// configured, read by getNotificationRecipients (in cmd/query-runner) to
+// build the Notifier list for a subscription.
+type Sinks struct {
+	Email bool
+	Slack bool
+
+	// Webhook is the owner's webhook sink, or nil if they haven't
+	// configured one.
+	Webhook *WebhookSink
+
+	// Phone is the owner's verified phone number for SMS notifications,
+	// or nil if they haven't completed phone verification (see
+	// serveSendPhoneNumberVerification/serveConfirmPhoneNumberVerification
+	// in cmd/query-runner).
+	Phone *string
+}
+
+// WebhookSink is a recipient's webhook delivery target.
+type WebhookSink struct {
+	URL    string
+	Secret string // optional; if empty, deliveries are sent unsigned.
+}
+
+// Owner returns the string form of the subject (user or org) that owns
+// this subscription.
+func (s Subscription) Owner() string { return s.Spec.Subject.String() }
+
+// Topic returns the saved query text this subscription is for.
+func (s Subscription) Topic() string { return s.Config.Query }
+
+// Filter narrows a RetrieveAll call down to subscriptions owned by Owner
+// and/or subscribed to Topic. A zero-value field is not filtered on.
+type Filter struct {
+	Owner string
+	Topic string
+}
+
+// PageInfo describes the requested page of a paginated listing. A Limit
+// of zero or less returns every matching subscription.
+type PageInfo struct {
+	Offset int
+	Limit  int
+}
+
+// Page is a single page of a paginated subscription listing, along with
+// the total number of subscriptions matching the filter (ignoring
+// pagination), so callers can render "page x of y".
+type Page struct {
+	Subscriptions []Subscription
+	Total         int
+}
+
+// Repository persists saved-search subscriptions.
+type Repository interface {
+	// Save creates sub if it doesn't exist, or updates it in place if it
+	// does (keyed on sub.ID), and returns its ID.
+	Save(ctx context.Context, sub Subscription) (string, error)
+
+	// Retrieve returns the subscription with the given ID, or
+	// ErrNotFound if none exists.
+	Retrieve(ctx context.Context, id string) (Subscription, error)
+
+	// RetrieveAll returns subscriptions matching filter, paginated
+	// according to page.
+	RetrieveAll(ctx context.Context, filter Filter, page PageInfo) (Page, error)
+
+	// Remove deletes the subscription with the given ID. It is a no-op
+	// if the subscription does not exist.
+	Remove(ctx context.Context, id string) error
+}
+
+// IDForSpec returns the Repository ID for a given saved query spec. It is
+// exported so callers that only have a spec (e.g. an HTTP handler
+// decoding a delete request) don't need to construct a Subscription just
+// to compute the ID.
+func IDForSpec(spec api.SavedQueryIDSpec) string {
+	return spec.Subject.String() + spec.Key
+}
@@ -0,0 +1,61 @@
+// Command query-runner executes saved searches on a schedule and notifies
+// subscribers (by email, Slack, webhook, or SMS) when they're created,
+// updated, deleted, or produce new results.
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/sourcegraph/sourcegraph/cmd/query-runner/internal/subscriptions/postgres"
+	"github.com/sourcegraph/sourcegraph/pkg/dbconn"
+	log15 "gopkg.in/inconshreveable/log15.v2"
+)
+
+func main() {
+	// Connect dbconn.Global and bring the query_runner_subscriptions
+	// table up to date before anything tries to use getSubscriptions():
+	// otherwise a fresh deployment's first saved-query request fails
+	// because the table doesn't exist yet.
+	if err := dbconn.ConnectToDB(""); err != nil {
+		log15.Error("query-runner: failed to connect to database", "error", err)
+		os.Exit(1)
+	}
+	if _, err := migrate.Exec(dbconn.Global, "postgres", postgres.Migration(), migrate.Up); err != nil {
+		log15.Error("query-runner: failed to run subscriptions migrations", "error", err)
+		os.Exit(1)
+	}
+
+	initSMPP()
+
+	// runSavedQueryExecutor is what actually runs saved searches on a
+	// schedule and reports matches via notifySavedQueryMatched; every
+	// other saved-query event in this file is reactive (driven by the
+	// frontend telling us something changed), but results notifications
+	// have to be driven by us re-running the query ourselves.
+	go runSavedQueryExecutor(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/saved-query-was-created-or-updated", requireInternalToken(serveSavedQueryWasCreatedOrUpdated))
+	mux.HandleFunc("/saved-query-was-deleted", requireInternalToken(serveSavedQueryWasDeleted))
+	mux.HandleFunc("/test-notification", requireInternalToken(rateLimitTestNotifications(serveTestNotification)))
+	mux.HandleFunc("/send-phone-number-verification", requireInternalToken(serveSendPhoneNumberVerification))
+	mux.HandleFunc("/confirm-phone-number-verification", requireInternalToken(serveConfirmPhoneNumberVerification))
+	mux.HandleFunc("/subscriptions", requireInternalToken(serveSubscriptionsList))
+	mux.HandleFunc("/subscriptions/get", requireInternalToken(serveSubscriptionGet))
+	mux.HandleFunc("/subscriptions/remove", requireInternalToken(serveSubscriptionRemove))
+	mux.HandleFunc("/subscriptions/sinks", requireInternalToken(serveSubscriptionSinksSet))
+
+	addr := os.Getenv("QUERY_RUNNER_ADDR")
+	if addr == "" {
+		addr = ":3183"
+	}
+	log15.Info("query-runner: listening", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log15.Error("query-runner: ListenAndServe failed", "error", err)
+		os.Exit(1)
+	}
+}
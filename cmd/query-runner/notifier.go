@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/sourcegraph/pkg/api"
+	log15 "gopkg.in/inconshreveable/log15.v2"
+)
+
+// notificationEventKind identifies why a Notifier is being invoked.
+type notificationEventKind string
+
+const (
+	notificationSubscribed   notificationEventKind = "subscribed"
+	notificationUnsubscribed notificationEventKind = "unsubscribed"
+	// notificationTest is sent by serveTestNotification so a user can
+	// confirm a sink is configured correctly before relying on it.
+	notificationTest notificationEventKind = "test"
+	// notificationMatch is sent when a saved search runs and finds
+	// results, carrying the matches themselves (see notificationEvent.Matches).
+	notificationMatch notificationEventKind = "match"
+)
+
+// notificationEvent is the payload delivered to a Notifier. It carries
+// enough information for every sink (email, Slack, webhook, ...) to
+// render its own message, so notifySavedQueryWasCreatedOrUpdated doesn't
+// need sink-specific branches any more.
+type notificationEvent struct {
+	Kind  notificationEventKind
+	Query api.SavedQuerySpecAndConfig
+
+	// ResultCount is the number of results the saved search matched, for
+	// sinks (like SMS) that render a compact summary. It is zero for
+	// subscribe/unsubscribe/test events, which aren't about a specific
+	// result set.
+	ResultCount int
+
+	// Matches holds the actual results for a notificationMatch event, so
+	// sinks that want the full payload (e.g. the webhook sink's CloudEvents
+	// "data.matches" field) don't just get a ResultCount. It is nil for
+	// every other event kind.
+	Matches interface{}
+}
+
+// Notifier delivers a notificationEvent to a single sink. Sourcegraph
+// ships email and Slack implementations (wrapping the pre-existing
+// emailNotifySubscribeUnsubscribe/slackNotify* functions), a webhook
+// implementation (see webhook_notifier.go), and an SMS implementation
+// (see sms_notifier.go); recipients may configure any number of them.
+type Notifier interface {
+	// ID identifies this notifier instance for logging and dead-letter
+	// purposes, e.g. "email:<subscription ID>" or "webhook:https://...".
+	ID() string
+
+	// Notify delivers event and returns a non-nil error on failure.
+	// Callers do not retry: notifyRecipient logs the error and
+	// notifyRecipientSync returns it. Retrying is up to each
+	// implementation (webhookNotifier retries with backoff and writes a
+	// dead-letter log entry; the others make a single attempt).
+	Notify(ctx context.Context, event notificationEvent) error
+}
+
+// emailNotifier delivers notificationEvents to a single email recipient
+// by reusing the existing subscribe/unsubscribe email templates.
+type emailNotifier struct {
+	recipient notificationRecipient
+}
+
+func (n *emailNotifier) ID() string { return "email:" + n.recipient.spec.String() }
+
+func (n *emailNotifier) Notify(ctx context.Context, event notificationEvent) error {
+	if event.Kind == notificationMatch {
+		// Match results are delivered via the webhook/SMS sinks; email
+		// only cares about subscribe/unsubscribe/test.
+		return nil
+	}
+	tmpl := notifySubscribedTemplate
+	if event.Kind == notificationUnsubscribed {
+		tmpl = notifyUnsubscribedTemplate
+	}
+	// A test notification reuses the "subscribed" template: there's
+	// nothing test-specific to say beyond "this is what you'd receive".
+	return emailNotifySubscribeUnsubscribe(ctx, n.recipient, event.Query, tmpl)
+}
+
+// slackNotifier delivers notificationEvents to a single Slack recipient
+// by reusing the existing slackNotifySubscribed/slackNotifyUnsubscribed
+// functions.
+type slackNotifier struct {
+	recipient notificationRecipient
+}
+
+func (n *slackNotifier) ID() string { return "slack:" + n.recipient.spec.String() }
+
+func (n *slackNotifier) Notify(ctx context.Context, event notificationEvent) error {
+	switch event.Kind {
+	case notificationMatch:
+		// Match results are delivered via the webhook/SMS sinks; Slack
+		// only cares about subscribe/unsubscribe/test.
+		return nil
+	case notificationUnsubscribed:
+		return slackNotifyUnsubscribed(ctx, n.recipient, event.Query)
+	case notificationTest:
+		return slackNotify(ctx, n.recipient,
+			fmt.Sprintf(`It worked! This is a test notification for the Sourcegraph saved search <%s|"%s">.`, searchURL(event.Query.Config.Query, utmSourceSlack), event.Query.Config.Description))
+	default:
+		return slackNotifySubscribed(ctx, n.recipient, event.Query)
+	}
+}
+
+// notifiersForRecipient returns every Notifier configured for recipient:
+// one per sink it has enabled (email, Slack, webhook, ...).
+func notifiersForRecipient(recipient notificationRecipient) []Notifier {
+	var notifiers []Notifier
+	if recipient.email {
+		notifiers = append(notifiers, &emailNotifier{recipient: recipient})
+	}
+	if recipient.slack {
+		notifiers = append(notifiers, &slackNotifier{recipient: recipient})
+	}
+	if recipient.webhook != nil {
+		notifiers = append(notifiers, newWebhookNotifier(*recipient.webhook))
+	}
+	if recipient.phone != nil {
+		notifiers = append(notifiers, &SMSNotifier{phone: *recipient.phone})
+	}
+	return notifiers
+}
+
+// notifyRecipient runs every Notifier configured for recipient against
+// event, logging (rather than failing the caller) on a per-sink basis so
+// one failing sink (e.g. an unreachable Slack webhook) doesn't prevent
+// the others from being notified.
+func notifyRecipient(ctx context.Context, recipient notificationRecipient, event notificationEvent) {
+	for _, notifier := range notifiersForRecipient(recipient) {
+		if err := notifier.Notify(ctx, event); err != nil {
+			log15.Error("Failed to deliver saved search notification.", "notifier", notifier.ID(), "query", event.Query.Config.Query, "error", err)
+		}
+	}
+}
+
+// notifyRecipientSync is like notifyRecipient, but returns the first
+// error encountered instead of only logging it. serveTestNotification
+// uses this so the caller testing a sink finds out immediately if it's
+// misconfigured.
+func notifyRecipientSync(ctx context.Context, recipient notificationRecipient, event notificationEvent) error {
+	for _, notifier := range notifiersForRecipient(recipient) {
+		if err := notifier.Notify(ctx, event); err != nil {
+			return errors.Wrapf(err, "delivering test notification via %s", notifier.ID())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Package queryrunnerapi defines the request types and a small HTTP
+// client for calling query-runner's internal endpoints: notifying it
+// that a saved query was created/updated/deleted, and asking it to send
+// a test notification.
+package queryrunnerapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/sourcegraph/sourcegraph/pkg/api"
+)
+
+// SavedQueryWasCreatedOrUpdatedArgs is the body of a request to
+// query-runner's /saved-query-was-created-or-updated endpoint.
+type SavedQueryWasCreatedOrUpdatedArgs struct {
+	SubjectAndConfig api.ConfigurationSubjectAndConfig
+
+	// DisableSubscriptionNotifications skips notifying subscribers of the
+	// change, e.g. when the frontend is just replaying existing saved
+	// queries rather than reacting to a real edit.
+	DisableSubscriptionNotifications bool
+}
+
+// SavedQueryWasDeletedArgs is the body of a request to query-runner's
+// /saved-query-was-deleted endpoint.
+type SavedQueryWasDeletedArgs struct {
+	Spec                              api.SavedQueryIDSpec
+	DisableSubscriptionNotifications bool
+}
+
+// TestNotificationArgs is the body of a request to query-runner's
+// /test-notification endpoint.
+type TestNotificationArgs struct {
+	Spec api.SavedQueryIDSpec
+}
+
+// internalToken is attached as a bearer token to every request this
+// client makes. It's read from the same QUERY_RUNNER_INTERNAL_TOKEN env
+// var query-runner itself reads (see cmd/query-runner/auth.go), so both
+// sides agree on the secret without a second piece of config. It's empty
+// (and so omitted) on deployments that haven't set it yet.
+var internalToken = os.Getenv("QUERY_RUNNER_INTERNAL_TOKEN")
+
+// Client calls query-runner's internal HTTP endpoints.
+type Client struct {
+	// URL is query-runner's base URL, e.g. "http://query-runner:3183".
+	URL string
+
+	// httpClient is overridable in tests; defaults to http.DefaultClient.
+	httpClient *http.Client
+}
+
+// New returns a Client pointed at url.
+func New(url string) *Client {
+	return &Client{URL: url, httpClient: http.DefaultClient}
+}
+
+func (c *Client) post(path string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.URL+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if internalToken != "" {
+		req.Header.Set("Authorization", "Bearer "+internalToken)
+	}
+
+	httpClient := c.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("query-runner: POST %s: %s: %s", path, resp.Status, respBody)
+	}
+	return nil
+}
+
+// NotifySavedQueryWasCreatedOrUpdated tells query-runner that a saved
+// query was created or updated.
+func (c *Client) NotifySavedQueryWasCreatedOrUpdated(args SavedQueryWasCreatedOrUpdatedArgs) error {
+	return c.post("/saved-query-was-created-or-updated", args)
+}
+
+// NotifySavedQueryWasDeleted tells query-runner that a saved query was
+// deleted.
+func (c *Client) NotifySavedQueryWasDeleted(args SavedQueryWasDeletedArgs) error {
+	return c.post("/saved-query-was-deleted", args)
+}
+
+// TestNotification asks query-runner to send a test notification for the
+// saved query identified by args.Spec.
+func (c *Client) TestNotification(args TestNotificationArgs) error {
+	return c.post("/test-notification", args)
+}
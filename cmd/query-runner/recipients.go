@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/sourcegraph/sourcegraph/cmd/query-runner/internal/subscriptions"
+)
+
+// notificationRecipientSpec identifies a notificationRecipient for
+// logging and diffing purposes. It's just the subscription ID
+// (subscriptions.IDForSpec) wrapped so it satisfies fmt.Stringer.
+type notificationRecipientSpec string
+
+func (s notificationRecipientSpec) String() string { return string(s) }
+
+// notificationRecipient is a single subscriber to notify about a saved
+// query event, and which sinks to notify them through.
+type notificationRecipient struct {
+	spec notificationRecipientSpec
+
+	email bool
+	slack bool
+
+	webhook *WebhookConfig
+	phone   *string
+}
+
+// getNotificationRecipients returns the recipients to notify for sub: its
+// own owner, with sinks populated from whatever they configured via
+// serveSubscriptionSinksSet (see subscriptions.Sinks). It returns no
+// recipients for the zero-value Subscription (e.g. the "old" side of a
+// brand-new subscription, or the "new" side of a deleted one) or if the
+// owner hasn't configured any sinks.
+//
+// This takes the Subscription snapshot directly rather than looking one
+// up by spec, because callers that just mutated the subscriptions store
+// (create/update/delete) already have the pre- and post-mutation
+// snapshots in hand; re-deriving them with a fresh Get afterwards would
+// read back already-mutated (or, for a delete, already-gone) state.
+func getNotificationRecipients(sub subscriptions.Subscription) []notificationRecipient {
+	if sub.ID == "" {
+		return nil
+	}
+
+	sinks := sub.Sinks
+	if !sinks.Email && !sinks.Slack && sinks.Webhook == nil && sinks.Phone == nil {
+		return nil
+	}
+
+	recipient := notificationRecipient{
+		spec:  notificationRecipientSpec(sub.ID),
+		email: sinks.Email,
+		slack: sinks.Slack,
+		phone: sinks.Phone,
+	}
+	if sinks.Webhook != nil {
+		recipient.webhook = &WebhookConfig{URL: sinks.Webhook.URL, Secret: sinks.Webhook.Secret}
+	}
+	return []notificationRecipient{recipient}
+}
+
+// diffNotificationRecipients splits old/new recipient sets (each either
+// empty or a single owner, see getNotificationRecipients) into the
+// recipients that should be told "you're unsubscribed" and "you're
+// subscribed": present in old but not new, and present in new but not
+// old, respectively. A recipient present in both isn't notified again.
+func diffNotificationRecipients(old, new []notificationRecipient) (removed, added []notificationRecipient) {
+	oldByKey := make(map[notificationRecipientSpec]notificationRecipient, len(old))
+	for _, r := range old {
+		oldByKey[r.spec] = r
+	}
+	newByKey := make(map[notificationRecipientSpec]notificationRecipient, len(new))
+	for _, r := range new {
+		newByKey[r.spec] = r
+	}
+
+	for key, r := range oldByKey {
+		if _, ok := newByKey[key]; !ok {
+			removed = append(removed, r)
+		}
+	}
+	for key, r := range newByKey {
+		if _, ok := oldByKey[key]; !ok {
+			added = append(added, r)
+		}
+	}
+	return removed, added
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/sourcegraph/cmd/query-runner/internal/smpp"
+	"github.com/sourcegraph/sourcegraph/pkg/api"
+	log15 "gopkg.in/inconshreveable/log15.v2"
+)
+
+// smsClient is the process-wide SMPP transceiver session used to send SMS
+// notifications. It is nil when QUERY_RUNNER_SMPP_HOST is unset, in which
+// case SMSNotifier.Notify is a no-op so deployments that haven't
+// configured SMS are unaffected.
+var smsClient *smpp.Client
+
+// initSMPP opens smsClient from the QUERY_RUNNER_SMPP_* environment
+// variables, following the same env-var-based deployment config already
+// used for QUERY_RUNNER_INTERNAL_TOKEN/QUERY_RUNNER_ADDR, since site
+// config has no SMPP schema. It is called once from main() during
+// startup.
+func initSMPP() {
+	host := os.Getenv("QUERY_RUNNER_SMPP_HOST")
+	if host == "" {
+		return
+	}
+	port, err := strconv.Atoi(os.Getenv("QUERY_RUNNER_SMPP_PORT"))
+	if err != nil {
+		log15.Error("smpp: invalid or missing QUERY_RUNNER_SMPP_PORT, SMS notifications are disabled", "error", err)
+		return
+	}
+	ton, _ := strconv.Atoi(os.Getenv("QUERY_RUNNER_SMPP_SOURCE_ADDR_TON"))
+	npi, _ := strconv.Atoi(os.Getenv("QUERY_RUNNER_SMPP_SOURCE_ADDR_NPI"))
+
+	client, err := smpp.Dial(smpp.Config{
+		Host:          host,
+		Port:          port,
+		SystemID:      os.Getenv("QUERY_RUNNER_SMPP_SYSTEM_ID"),
+		Password:      os.Getenv("QUERY_RUNNER_SMPP_PASSWORD"),
+		SystemType:    os.Getenv("QUERY_RUNNER_SMPP_SYSTEM_TYPE"),
+		SourceAddr:    os.Getenv("QUERY_RUNNER_SMPP_SOURCE_ADDR"),
+		SourceAddrTON: uint8(ton),
+		SourceAddrNPI: uint8(npi),
+	})
+	if err != nil {
+		log15.Error("smpp: failed to connect to SMSC, SMS notifications are disabled", "error", err)
+		return
+	}
+	smsClient = client
+}
+
+// SMSNotifier delivers notificationEvents to a single recipient's verified
+// phone number over the process-wide SMPP session.
+type SMSNotifier struct {
+	phone string
+}
+
+func (n *SMSNotifier) ID() string { return "sms:" + n.phone }
+
+func (n *SMSNotifier) Notify(ctx context.Context, event notificationEvent) error {
+	if smsClient == nil {
+		return nil
+	}
+	return smsClient.Send(n.phone, smsBody(event.Query, event.ResultCount))
+}
+
+// smsBody renders the compact SMS body sent for a saved search
+// notification: its description, result count (when known), and a short
+// URL to the search.
+func smsBody(query api.SavedQuerySpecAndConfig, resultCount int) string {
+	desc := query.Config.Description
+	if desc == "" {
+		desc = query.Config.Query
+	}
+	url := searchURL(query.Config.Query, utmSourceSlack)
+	if resultCount > 0 {
+		return fmt.Sprintf("%s (%d results): %s", desc, resultCount, url)
+	}
+	return fmt.Sprintf("%s: %s", desc, url)
+}
+
+// confirmPhoneNumberArgs is the body of a request to
+// serveSendPhoneNumberVerification.
+type confirmPhoneNumberArgs struct {
+	Phone string `json:"phone"`
+}
+
+// confirmPhoneNumberVerificationArgs is the body of a request to
+// serveConfirmPhoneNumberVerification.
+type confirmPhoneNumberVerificationArgs struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+}
+
+// phoneVerificationTTL bounds how long a code sent by
+// serveSendPhoneNumberVerification remains valid, so a code that's never
+// confirmed doesn't stick around indefinitely.
+const phoneVerificationTTL = 10 * time.Minute
+
+// pendingPhoneVerification is a code query-runner sent to a phone number
+// and hasn't seen confirmed yet.
+type pendingPhoneVerification struct {
+	code      string
+	expiresAt time.Time
+}
+
+// pendingPhoneVerifications holds outstanding codes server-side, keyed by
+// phone number, so serveSendPhoneNumberVerification never has to return
+// the code to the caller: query-runner is the only party that needs to
+// know it, since it's also the one that verifies it.
+var (
+	pendingPhoneVerificationsMu sync.Mutex
+	pendingPhoneVerifications   = map[string]pendingPhoneVerification{}
+)
+
+func init() {
+	go evictPendingPhoneVerifications()
+}
+
+// evictPendingPhoneVerifications periodically purges expired codes from
+// pendingPhoneVerifications. Without this, a code is only ever removed
+// on successful confirmation, so a caller requesting codes for phone
+// numbers it never confirms (e.g. a malicious internal client) would
+// grow the map unbounded. It runs for the lifetime of the process.
+func evictPendingPhoneVerifications() {
+	ticker := time.NewTicker(phoneVerificationTTL)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		pendingPhoneVerificationsMu.Lock()
+		for phone, pending := range pendingPhoneVerifications {
+			if now.After(pending.expiresAt) {
+				delete(pendingPhoneVerifications, phone)
+			}
+		}
+		pendingPhoneVerificationsMu.Unlock()
+	}
+}
+
+// serveSendPhoneNumberVerification sends a one-time confirmation code to
+// the given phone number over SMS, the SMS equivalent of
+// emailNotifySubscribeUnsubscribe's subscribe confirmation step. The code
+// is held server-side (see pendingPhoneVerifications) and checked by
+// serveConfirmPhoneNumberVerification; it is never returned to the
+// caller, since that's the same party asking to prove possession of the
+// phone number.
+func serveSendPhoneNumberVerification(w http.ResponseWriter, r *http.Request) {
+	var args confirmPhoneNumberArgs
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		writeError(w, errors.Wrap(err, "decoding JSON arguments"))
+		return
+	}
+	if smsClient == nil {
+		writeError(w, errors.New("SMS notifications are not configured on this instance"))
+		return
+	}
+
+	code, err := generateVerificationCode()
+	if err != nil {
+		writeError(w, errors.Wrap(err, "generating verification code"))
+		return
+	}
+	if err := smsClient.Send(args.Phone, fmt.Sprintf("Your Sourcegraph verification code is %s", code)); err != nil {
+		writeError(w, errors.Wrap(err, "sending verification SMS"))
+		return
+	}
+
+	pendingPhoneVerificationsMu.Lock()
+	pendingPhoneVerifications[args.Phone] = pendingPhoneVerification{code: code, expiresAt: time.Now().Add(phoneVerificationTTL)}
+	pendingPhoneVerificationsMu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveConfirmPhoneNumberVerification checks a code the user read back
+// from an SMS sent by serveSendPhoneNumberVerification. On success, the
+// code is consumed (it cannot be reused) and the caller is responsible
+// for recording the now-verified phone number against the subscription's
+// sinks (see serveSubscriptionSinksSet).
+func serveConfirmPhoneNumberVerification(w http.ResponseWriter, r *http.Request) {
+	var args confirmPhoneNumberVerificationArgs
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		writeError(w, errors.Wrap(err, "decoding JSON arguments"))
+		return
+	}
+
+	pendingPhoneVerificationsMu.Lock()
+	pending, ok := pendingPhoneVerifications[args.Phone]
+	if ok && time.Now().Before(pending.expiresAt) && subtle.ConstantTimeCompare([]byte(pending.code), []byte(args.Code)) == 1 {
+		delete(pendingPhoneVerifications, args.Phone)
+	} else {
+		ok = false
+	}
+	pendingPhoneVerificationsMu.Unlock()
+
+	writeJSON(w, struct {
+		Verified bool `json:"verified"`
+	}{Verified: ok})
+}
+
+// generateVerificationCode returns a random 6-digit numeric code suitable
+// for a user to read back over the phone/SMS.
+func generateVerificationCode() (string, error) {
+	var b [3]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	n := (int(b[0])<<16 | int(b[1])<<8 | int(b[2])) % 1000000
+	return fmt.Sprintf("%06d", n), nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/sourcegraph/cmd/query-runner/internal/subscriptions"
+	log15 "gopkg.in/inconshreveable/log15.v2"
+)
+
+// subscriptionSinksSetArgs is the body of a request to
+// serveSubscriptionSinksSet.
+type subscriptionSinksSetArgs struct {
+	ID    string              `json:"id"`
+	Sinks subscriptions.Sinks `json:"sinks"`
+}
+
+// serveSubscriptionSinksSet sets which notification channels a
+// subscription's owner should be notified through; getNotificationRecipients
+// reads this back to build the Notifier list for saved-search events.
+func serveSubscriptionSinksSet(w http.ResponseWriter, r *http.Request) {
+	var args subscriptionSinksSetArgs
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		writeError(w, errors.Wrap(err, "decoding JSON arguments"))
+		return
+	}
+
+	if err := getSubscriptions().SetSinks(r.Context(), args.ID, args.Sinks); err == subscriptions.ErrNotFound {
+		http.Error(w, "subscription not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, errors.Wrap(err, "setting subscription sinks"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeJSON writes v to w as a JSON response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log15.Error("failed to encode JSON response", "error", err)
+	}
+}
+
+// subscriptionsListResponse is the JSON response for serveSubscriptionsList.
+type subscriptionsListResponse struct {
+	Subscriptions []subscriptions.Subscription `json:"subscriptions"`
+	Total         int                          `json:"total"`
+}
+
+// serveSubscriptionsList lists subscriptions, optionally filtered by the
+// "owner" and/or "topic" query parameters and paginated with "limit"/
+// "offset". This is the internal REST surface that lets other internal
+// services (and, eventually, a GraphQL resolver) inspect saved-search
+// subscriptions directly, instead of query-runner being a write-only push
+// channel for the frontend.
+func serveSubscriptionsList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	page := subscriptions.PageInfo{}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, errors.Wrap(err, "parsing limit"))
+			return
+		}
+		page.Limit = limit
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, errors.Wrap(err, "parsing offset"))
+			return
+		}
+		page.Offset = offset
+	}
+
+	result, err := getSubscriptions().List(r.Context(), subscriptions.Filter{
+		Owner: q.Get("owner"),
+		Topic: q.Get("topic"),
+	}, page)
+	if err != nil {
+		writeError(w, errors.Wrap(err, "listing subscriptions"))
+		return
+	}
+
+	writeJSON(w, subscriptionsListResponse{Subscriptions: result.Subscriptions, Total: result.Total})
+}
+
+// serveSubscriptionGet returns a single subscription by its ID (the "id"
+// query parameter, see subscriptions.IDForSpec).
+func serveSubscriptionGet(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	sub, err := getSubscriptions().Get(r.Context(), id)
+	if err == subscriptions.ErrNotFound {
+		http.Error(w, "subscription not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, errors.Wrap(err, "looking up subscription"))
+		return
+	}
+	writeJSON(w, sub)
+}
+
+// serveSubscriptionRemove deletes a single subscription by its ID (the
+// "id" query parameter, see subscriptions.IDForSpec). Unlike
+// serveSavedQueryWasDeleted, this does not notify recipients or touch the
+// frontend's saved query info cache: it is for operators/internal tooling
+// removing a subscription directly, not for the frontend's create/update/
+// delete webhook flow.
+func serveSubscriptionRemove(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if err := getSubscriptions().Remove(r.Context(), id); err != nil {
+		writeError(w, errors.Wrap(err, "removing subscription"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// TODO(slimsag/farhan): expose this same CRUD surface over GraphQL once
+// query-runner's subscriptions live behind an internal gRPC/GraphQL
+// gateway; for now REST is all internal callers need.
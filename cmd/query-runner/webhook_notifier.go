@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/sourcegraph/pkg/api"
+	"github.com/sourcegraph/sourcegraph/pkg/conf"
+	log15 "gopkg.in/inconshreveable/log15.v2"
+)
+
+// WebhookConfig is a recipient's webhook sink configuration: where to POST
+// CloudEvents deliveries, and (optionally) the shared secret used to sign
+// them.
+type WebhookConfig struct {
+	URL    string
+	Secret string // optional; if empty, deliveries are sent unsigned.
+}
+
+// webhookMaxAttempts bounds the number of attempts (the first try
+// included) webhookNotifier makes at a single delivery before giving up
+// and logging it to the dead-letter log.
+const webhookMaxAttempts = 5
+
+// webhookInitialBackoff is the delay before the first retry; each
+// subsequent retry doubles it.
+const webhookInitialBackoff = 2 * time.Second
+
+// cloudEventEnvelope is the JSON body POSTed to a webhook sink. It follows
+// the CloudEvents 1.0 spec (https://github.com/cloudevents/spec).
+type cloudEventEnvelope struct {
+	SpecVersion     string      `json:"specversion"`
+	Type            string      `json:"type"`
+	Source          string      `json:"source"`
+	ID              string      `json:"id"`
+	Time            string      `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Subject         string      `json:"subject"`
+	Data            interface{} `json:"data"`
+}
+
+// cloudEventData is the payload carried in a saved-search CloudEvent's
+// "data" field.
+type cloudEventData struct {
+	Query   api.SavedQuerySpecAndConfig `json:"query"`
+	Matches interface{}                 `json:"matches,omitempty"`
+}
+
+// webhookNotifier delivers notificationEvents to a single recipient's
+// webhook sink as a CloudEvents 1.0 JSON envelope, signed with an
+// HMAC-SHA256 over the request body when the recipient configured a
+// secret.
+type webhookNotifier struct {
+	config     WebhookConfig
+	httpClient *http.Client
+}
+
+func newWebhookNotifier(config WebhookConfig) *webhookNotifier {
+	return &webhookNotifier{config: config, httpClient: http.DefaultClient}
+}
+
+func (n *webhookNotifier) ID() string { return "webhook:" + n.config.URL }
+
+func (n *webhookNotifier) Notify(ctx context.Context, event notificationEvent) error {
+	body, err := json.Marshal(cloudEventEnvelope{
+		SpecVersion:     "1.0",
+		Type:            cloudEventType(event.Kind),
+		Source:          conf.Get().Critical.ExternalURL,
+		ID:              uuid.NewV4().String(),
+		Time:            time.Now().UTC().Format(time.RFC3339),
+		DataContentType: "application/json",
+		Subject:         event.Query.Spec.Key,
+		Data:            cloudEventData{Query: event.Query, Matches: event.Matches},
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshaling CloudEvents envelope")
+	}
+
+	backoff := webhookInitialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if err := n.deliver(ctx, body); err != nil {
+			lastErr = err
+			log15.Warn("webhook notifier: delivery attempt failed", "url", n.config.URL, "attempt", attempt, "error", err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+			continue
+		}
+		return nil
+	}
+
+	// Every attempt failed: record it so the failure isn't silently
+	// dropped like the old bare log15.Error call used to.
+	deadLetter(n.config.URL, body, lastErr)
+	return errors.Wrapf(lastErr, "webhook delivery to %s failed after %d attempts", n.config.URL, webhookMaxAttempts)
+}
+
+func (n *webhookNotifier) deliver(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest("POST", n.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	if n.config.Secret != "" {
+		req.Header.Set("X-Sourcegraph-Signature", "sha256="+sign(n.config.Secret, body))
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook sink responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sign returns the hex-encoded HMAC-SHA256 of body using secret as the key.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// cloudEventType maps a notificationEventKind to the CloudEvents "type"
+// field used by saved-search webhook deliveries.
+func cloudEventType(kind notificationEventKind) string {
+	switch kind {
+	case notificationUnsubscribed:
+		return "com.sourcegraph.savedsearch.deleted"
+	case notificationMatch:
+		return "com.sourcegraph.savedsearch.match"
+	case notificationTest:
+		return "com.sourcegraph.savedsearch.created"
+	default:
+		return "com.sourcegraph.savedsearch.created"
+	}
+}
+
+// deadLetter records a webhook delivery that exhausted all of its retry
+// attempts, so it isn't silently dropped.
+//
+// TODO(slimsag/farhan): write these to a durable table once query-runner
+// has a place to put them; for now structured logging is strictly better
+// than the bare log15.Error we used to emit on the first failure.
+func deadLetter(url string, body []byte, err error) {
+	log15.Error("webhook notifier: delivery exhausted all retries, dropping", "url", url, "body", string(body), "error", err)
+}
@@ -5,11 +5,26 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"github.com/mattn/go-isatty"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// Exit codes. CI jobs that run srcsearch in a pipeline can use these to
+// tell "ran fine, results are complete" apart from "ran fine, but some
+// repositories were still cloning/missing/timed out", without having to
+// parse the output.
+const (
+	exitOK             = 0
+	exitError          = 1
+	exitPartialResults = 2
 )
 
 func main() {
@@ -23,6 +38,10 @@ The options are:
 
 	-config=$HOME/src-config.json    specifies a file containing {"accessToken": "<secret>", "endpoint": "https://sourcegraph.com"}
 	-endpoint=                       specifies the endpoint to use e.g. "https://sourcegraph.com" (overrides -config, if any)
+	-format=json                     output format: json, jsonl, text, or tsv
+	-fields=                         comma-separated dotted field paths to print in text/tsv format, e.g. repository.name,file.path,lineMatches.preview
+	-limit=0                         stop after this many results (0 means no limit)
+	-timeout=30s                     give up after this long
 
 Examples:
 
@@ -30,6 +49,10 @@ Examples:
 
         $ srcsearch 'repogroup:sample error'
 
+  Stream compact TSV results into another tool as they arrive:
+
+        $ srcsearch -format=tsv -fields=repository.name,file.path 'repogroup:sample error' | awk '{print $1}'
+
 Other tips:
 
   Query syntax: https://about.sourcegraph.com/docs/search/query-syntax/
@@ -38,34 +61,162 @@ Other tips:
 	// Configure logging.
 	log.SetFlags(0)
 	log.SetPrefix("")
-	endpoint := flag.String("endpoint", "https://sourcegraph.com", "")
+
+	configPath := flag.String("config", defaultConfigPath(), "specifies a file containing {\"accessToken\": \"<secret>\", \"endpoint\": \"...\"}")
+	endpointFlag := flag.String("endpoint", "", "specifies the endpoint to use e.g. \"https://sourcegraph.com\" (overrides -config, if any)")
+	format := flag.String("format", "json", "output format: json, jsonl, text, or tsv")
+	fields := flag.String("fields", "", "comma-separated dotted field paths to print in text/tsv format")
+	limit := flag.Int("limit", 0, "stop after this many results (0 means no limit)")
+	timeout := flag.Duration("timeout", 30*time.Second, "give up after this long")
 	flag.Parse()
 	if flag.NArg() != 1 {
 		log.Println("expected exactly one argument: the search query")
 		log.Println(usage)
-		os.Exit(1)
+		os.Exit(exitError)
 	}
 	searchQuery := flag.Arg(0)
-	if err := srcsearch(*endpoint, searchQuery); err != nil {
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("srcsearch: reading -config: %v", err)
+	}
+
+	endpoint := "https://sourcegraph.com"
+	if cfg != nil && cfg.Endpoint != "" {
+		endpoint = cfg.Endpoint
+	}
+	if *endpointFlag != "" {
+		endpoint = *endpointFlag
+	}
+	var accessToken string
+	if cfg != nil {
+		accessToken = cfg.AccessToken
+	}
+
+	f, err := newFormatter(*format, strings.Split(*fields, ","))
+	if err != nil {
 		log.Fatalf("srcsearch: %v", err)
 	}
+
+	code, err := srcsearch(endpoint, accessToken, searchQuery, *limit, *timeout, f)
+	if err != nil {
+		log.Fatalf("srcsearch: %v", err)
+	}
+	os.Exit(code)
+}
+
+// config is the shape of the -config JSON file.
+type config struct {
+	AccessToken string `json:"accessToken"`
+	Endpoint    string `json:"endpoint"`
 }
 
-func srcsearch(endpoint, searchQuery string) error {
-	res, err := search(endpoint, searchQuery)
+// defaultConfigPath returns $HOME/src-config.json, or "" if $HOME can't
+// be determined.
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return err
+		return ""
+	}
+	return filepath.Join(home, "src-config.json")
+}
+
+// loadConfig reads and parses the -config file. A missing file at the
+// default path is not an error (most users won't have one); a missing
+// file at an explicitly-specified path is.
+func loadConfig(path string) (*config, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && path == defaultConfigPath() {
+		return nil, nil
 	}
-	// Print the formatted JSON.
-	fmted, err := marshalIndent(res)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Println(string(fmted))
-	return nil
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+	return &cfg, nil
+}
+
+// batchSize is how many results srcsearch asks the server for on its
+// first request; each subsequent request (if more results might exist)
+// doubles it. The search API has no result cursor to resume from, so
+// "streaming" here means re-running the query with an increasing
+// count: and printing only the results past where the previous request
+// left off — still one round-trip per batch, but each batch is printed
+// (and can be consumed downstream) as soon as it arrives, instead of
+// only after the entire result set has been fetched.
+const batchSize = 30
+
+func srcsearch(endpoint, accessToken, searchQuery string, limit int, timeout time.Duration, f formatter) (int, error) {
+	deadline := time.Now().Add(timeout)
+
+	printed := 0
+	var lastStatus searchResults
+	for count := batchSize; ; count *= 2 {
+		if time.Now().After(deadline) {
+			return exitError, fmt.Errorf("timed out after %s", timeout)
+		}
+		// Bound the request itself by what's left of the deadline, so a
+		// slow response can't run past -timeout.
+		http.DefaultClient.Timeout = time.Until(deadline)
+
+		res, err := search(endpoint, accessToken, fmt.Sprintf("%s count:%d", searchQuery, count))
+		if err != nil {
+			return exitError, err
+		}
+		lastStatus = res.Search.Results
+
+		// A larger count: isn't guaranteed to return a superset of the
+		// previous request's results: Cloning/Missing/Timedout repos
+		// make results inherently flaky, so a later request can come
+		// back with fewer total results than we've already printed.
+		// Treat that as "nothing new to print" instead of panicking.
+		if resultsShrank(printed, len(res.Search.Results.Results)) {
+			break
+		}
+
+		newResults := res.Search.Results.Results[printed:]
+		for _, result := range newResults {
+			if limit > 0 && printed >= limit {
+				break
+			}
+			if err := f.WriteResult(os.Stdout, result); err != nil {
+				return exitError, err
+			}
+			printed++
+		}
+
+		reachedLimit := limit > 0 && printed >= limit
+		gotFewerThanAsked := len(res.Search.Results.Results) < count
+		if reachedLimit || gotFewerThanAsked {
+			break
+		}
+	}
+	if err := f.Close(os.Stdout); err != nil {
+		return exitError, err
+	}
+
+	if len(lastStatus.Cloning) > 0 || len(lastStatus.Missing) > 0 || len(lastStatus.Timedout) > 0 {
+		return exitPartialResults, nil
+	}
+	return exitOK, nil
 }
 
-func search(endpoint, searchQuery string) (*result, error) {
+// resultsShrank reports whether a later batch came back with fewer total
+// results than printed already covers, which would otherwise panic on
+// the res.Search.Results.Results[printed:] slice in srcsearch.
+func resultsShrank(printed, total int) bool {
+	return printed > total
+}
+
+// search performs a single GraphQL search request for searchQuery
+// (already including any count: filter) and returns the decoded result.
+func search(endpoint, accessToken, searchQuery string) (*result, error) {
 	query := `fragment FileMatchFields on FileMatch {
 				repository {
 					name
@@ -184,7 +335,7 @@ func search(endpoint, searchQuery string) (*result, error) {
 `
 
 	vars := map[string]interface{}{"query": nullString(searchQuery)}
-	return apiRequest(query, vars, endpoint)
+	return apiRequest(query, vars, endpoint, accessToken)
 }
 
 // gqlURL returns the URL to the GraphQL endpoint for the given Sourcegraph
@@ -205,7 +356,8 @@ type result struct {
 // apiRequest makes an API request and returns the result.
 // query is the GraphQL query.
 // vars contains the GraphQL query variables.
-func apiRequest(query string, vars map[string]interface{}, endpoint string) (*result, error) {
+// accessToken, if non-empty, is sent as "Authorization: token <accessToken>".
+func apiRequest(query string, vars map[string]interface{}, endpoint, accessToken string) (*result, error) {
 
 	// Create the JSON object.
 	var buf bytes.Buffer
@@ -217,11 +369,13 @@ func apiRequest(query string, vars map[string]interface{}, endpoint string) (*re
 	}
 
 	// Create the HTTP request.
-	req, err := http.NewRequest("POST", gqlURL(endpoint), nil)
+	req, err := http.NewRequest("POST", gqlURL(endpoint), &buf)
 	if err != nil {
 		return nil, err
 	}
-	req.Body = ioutil.NopCloser(&buf)
+	if accessToken != "" {
+		req.Header.Set("Authorization", "token "+accessToken)
+	}
 
 	// Perform the request.
 	resp, err := http.DefaultClient.Do(req)
@@ -309,3 +463,140 @@ type searchResults struct {
 	ResultCount                int
 	ElapsedMilliseconds        int
 }
+
+// formatter renders search results as they arrive, in one of the
+// supported -format modes.
+type formatter interface {
+	// WriteResult is called once per result, in the order results
+	// arrived in.
+	WriteResult(w *os.File, result map[string]interface{}) error
+
+	// Close is called once after the last result, so formats that can't
+	// be streamed incrementally (json) can flush what they've buffered.
+	Close(w *os.File) error
+}
+
+func newFormatter(format string, fields []string) (formatter, error) {
+	switch format {
+	case "json":
+		return &jsonFormatter{}, nil
+	case "jsonl":
+		return jsonlFormatter{}, nil
+	case "text":
+		return fieldFormatter{fields: nonEmpty(fields), sep: "  "}, nil
+	case "tsv":
+		if len(nonEmpty(fields)) == 0 {
+			return nil, fmt.Errorf("-format=tsv requires -fields=")
+		}
+		return fieldFormatter{fields: nonEmpty(fields), sep: "\t"}, nil
+	default:
+		return nil, fmt.Errorf("unrecognized -format %q (want json, jsonl, text, or tsv)", format)
+	}
+}
+
+func nonEmpty(ss []string) []string {
+	var out []string
+	for _, s := range ss {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// jsonFormatter buffers every result and prints them as a single
+// formatted JSON array once Close is called. The array holds only the
+// results; the response's site and search status fields (limitHit,
+// cloning, missing, ...) are not printed.
+type jsonFormatter struct {
+	results []map[string]interface{}
+}
+
+func (f *jsonFormatter) WriteResult(w *os.File, result map[string]interface{}) error {
+	f.results = append(f.results, result)
+	return nil
+}
+
+func (f *jsonFormatter) Close(w *os.File) error {
+	b, err := marshalIndent(f.results)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// jsonlFormatter emits one compact JSON object per line, as soon as each
+// result arrives.
+type jsonlFormatter struct{}
+
+func (jsonlFormatter) WriteResult(w *os.File, result map[string]interface{}) error {
+	return json.NewEncoder(w).Encode(result)
+}
+
+func (jsonlFormatter) Close(w *os.File) error { return nil }
+
+// fieldFormatter prints one line per result containing the values of
+// fields (dotted paths into the result, e.g. "repository.name"),
+// separated by sep.
+type fieldFormatter struct {
+	fields []string
+	sep    string
+}
+
+func (f fieldFormatter) WriteResult(w *os.File, result map[string]interface{}) error {
+	fields := f.fields
+	if len(fields) == 0 {
+		fields = []string{"__typename", "repository.name"}
+	}
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = fieldValue(result, field)
+	}
+	_, err := fmt.Fprintln(w, strings.Join(values, f.sep))
+	return err
+}
+
+func (f fieldFormatter) Close(w *os.File) error { return nil }
+
+// fieldValue looks up the dotted path (e.g. "lineMatches.preview") in
+// result. Where the path passes through a JSON array (e.g. lineMatches),
+// it follows the first element, since dotted-path selectors are meant
+// for compact single-value output, not multi-value expansion.
+func fieldValue(result map[string]interface{}, path string) string {
+	var cur interface{} = result
+	for _, part := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			cur = v[part]
+		case []interface{}:
+			if len(v) == 0 {
+				return ""
+			}
+			m, ok := v[0].(map[string]interface{})
+			if !ok {
+				return ""
+			}
+			cur = m[part]
+		default:
+			return ""
+		}
+	}
+	return stringify(cur)
+}
+
+func stringify(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
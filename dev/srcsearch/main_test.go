@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestResultsShrank(t *testing.T) {
+	tests := []struct {
+		printed, total int
+		want           bool
+	}{
+		{printed: 0, total: 0, want: false},
+		{printed: 0, total: 30, want: false},
+		{printed: 30, total: 30, want: false},
+		{printed: 30, total: 60, want: false},
+		{printed: 31, total: 30, want: true},
+	}
+	for _, test := range tests {
+		if got := resultsShrank(test.printed, test.total); got != test.want {
+			t.Errorf("resultsShrank(%d, %d) = %v, want %v", test.printed, test.total, got, test.want)
+		}
+	}
+}